@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Classifier identifies the language of file content when filename-based
+// matching (mExt/mName) is inconclusive. candidates, when non-empty,
+// narrows the search to those language names; an empty map means any
+// language in the table is fair game.
+type Classifier interface {
+	Classify(content []byte, candidates map[string]float64) []string
+}
+
+// chainClassifier runs a sequence of detectors in order and returns the
+// first one that reaches a verdict, mirroring go-enry's strategy pipeline:
+// shebang, then modeline, then a frequency-table Bayes classifier.
+// When candidates is non-empty, a verdict naming none of them is
+// discarded and the next detector is tried.
+type chainClassifier struct {
+	detectors []func(content []byte, candidates map[string]float64) []string
+}
+
+func (c chainClassifier) Classify(content []byte, candidates map[string]float64) []string {
+	for _, detect := range c.detectors {
+		langs := detect(content, candidates)
+		if len(candidates) > 0 {
+			var kept []string
+			for _, lang := range langs {
+				if _, ok := candidates[lang]; ok {
+					kept = append(kept, lang)
+				}
+			}
+			langs = kept
+		}
+		if len(langs) > 0 {
+			return langs
+		}
+	}
+	return nil
+}
+
+var defaultClassifier = chainClassifier{
+	detectors: []func([]byte, map[string]float64) []string{
+		classifyShebang,
+		classifyModeline,
+		classifyBayes,
+	},
+}
+
+// shebangLanguages maps interpreter names, as they appear on a "#!" line,
+// to the language name used in the languages table.
+var shebangLanguages = map[string]string{
+	"python":  "Python",
+	"python2": "Python",
+	"python3": "Python",
+	"ruby":    "Ruby",
+	"perl":    "Perl",
+	"sh":      "Shell",
+	"bash":    "Bash",
+}
+
+func classifyShebang(content []byte, candidates map[string]float64) []string {
+	line := firstLine(content)
+	if !strings.HasPrefix(line, "#!") {
+		return nil
+	}
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return nil
+	}
+	interp := path.Base(fields[0])
+	if interp == "env" && len(fields) > 1 {
+		interp = fields[1]
+	}
+	if lang, ok := shebangLanguages[interp]; ok {
+		return []string{lang}
+	}
+	return nil
+}
+
+// modelineLanguages maps the Emacs/Vim mode name to our language name.
+var modelineLanguages = map[string]string{
+	"python": "Python",
+	"ruby":   "Ruby",
+	"perl":   "Perl",
+	"sh":     "Shell",
+}
+
+var (
+	emacsModeline = regexp.MustCompile(`-\*-.*mode:\s*([a-zA-Z0-9_+-]+).*-\*-`)
+	vimModeline   = regexp.MustCompile(`vim:\s*(?:set\s+)?(?:ft|filetype)=([a-zA-Z0-9_+-]+)`)
+)
+
+func classifyModeline(content []byte, candidates map[string]float64) []string {
+	line := firstLine(content)
+	if m := emacsModeline.FindStringSubmatch(line); m != nil {
+		if lang, ok := modelineLanguages[strings.ToLower(m[1])]; ok {
+			return []string{lang}
+		}
+	}
+	for _, line := range lastLines(content, 5) {
+		if m := vimModeline.FindStringSubmatch(line); m != nil {
+			if lang, ok := modelineLanguages[strings.ToLower(m[1])]; ok {
+				return []string{lang}
+			}
+		}
+	}
+	return nil
+}
+
+func firstLine(content []byte) string {
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		content = content[:i]
+	}
+	return string(bytes.TrimRight(content, "\r"))
+}
+
+func lastLines(content []byte, n int) []string {
+	lines := strings.Split(string(content), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// classifyBayes is a naive Bayes classifier over per-language token
+// frequencies in langTokenFreq (see classify_data.go). It only ever
+// reaches a verdict for languages present in that table, so it never
+// overrides a more specific detector earlier in the chain.
+func classifyBayes(content []byte, candidates map[string]float64) []string {
+	scores := make(map[string]float64, len(langTokenFreq))
+	for name := range langTokenFreq {
+		if len(candidates) > 0 {
+			if _, ok := candidates[name]; !ok {
+				continue
+			}
+		}
+		scores[name] = 0
+	}
+	if len(scores) == 0 {
+		return nil
+	}
+
+	tokens := bufio.NewScanner(bytes.NewReader(content))
+	tokens.Split(bufio.ScanWords)
+	for tokens.Scan() {
+		tok := tokens.Text()
+		for name := range scores {
+			scores[name] += langTokenFreq[name][tok]
+		}
+	}
+
+	names := make([]string, 0, len(scores))
+	for name := range scores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestScore := "", 0.0
+	for _, name := range names {
+		if scores[name] > bestScore {
+			best, bestScore = name, scores[name]
+		}
+	}
+	if best == "" {
+		return nil
+	}
+	return []string{best}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+// ignorePattern is one non-comment, non-blank line from a .gitignore or
+// .slocignore file.
+type ignorePattern struct {
+	pattern  string
+	negate   bool // "!pattern" re-includes a path an earlier pattern ignored
+	dirOnly  bool // "pattern/" only matches directories
+	anchored bool // pattern contained a "/" before its final segment
+}
+
+// ignoreSet is the patterns loaded from one directory's ignore files,
+// along with the directory they're relative to.
+type ignoreSet struct {
+	base     string
+	patterns []ignorePattern
+}
+
+// loadIgnoreSet reads .gitignore and .slocignore from dir, in that order,
+// and returns the combined patterns, or ok=false if neither file yields
+// any pattern (missing, or only blank and comment lines).
+func loadIgnoreSet(dir string) (ignoreSet, bool) {
+	set := ignoreSet{base: dir}
+	set.patterns = append(set.patterns, parseIgnoreFile(dir, ".gitignore")...)
+	set.patterns = append(set.patterns, parseIgnoreFile(dir, ".slocignore")...)
+	return set, len(set.patterns) > 0
+}
+
+func parseIgnoreFile(dir, name string) []ignorePattern {
+	f, err := os.Open(path.Join(dir, name))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		p.pattern = line
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// relTo returns name relative to base (both slash-separated), or
+// ok=false if name isn't under base.
+func relTo(base, name string) (rel string, ok bool) {
+	base = path.Clean(base)
+	if base == "." {
+		return name, true
+	}
+	prefix := base + "/"
+	if !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+	return name[len(prefix):], true
+}
+
+// match reports whether relPath (relative to the ignore file's directory)
+// matches this pattern.
+func (p ignorePattern) match(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.anchored {
+		return matchGlob(p.pattern, relPath)
+	}
+	// Unanchored patterns (no "/" before the last segment) match at any
+	// depth, so try the full relative path and the bare basename.
+	if matchGlob(p.pattern, relPath) {
+		return true
+	}
+	return matchGlob(p.pattern, path.Base(relPath))
+}
+
+// matchGlob extends path.Match with "**", which the stdlib glob doesn't
+// support: it matches zero or more whole path segments.
+func matchGlob(pattern, name string) bool {
+	switch {
+	case strings.HasPrefix(pattern, "**/"):
+		suffix := pattern[3:]
+		segs := strings.Split(name, "/")
+		for i := range segs {
+			if matchGlob(suffix, strings.Join(segs[i:], "/")) {
+				return true
+			}
+		}
+		return false
+	case strings.HasSuffix(pattern, "/**"):
+		prefix := pattern[:len(pattern)-3]
+		return name == prefix || strings.HasPrefix(name, prefix+"/")
+	case strings.Contains(pattern, "/**/"):
+		parts := strings.SplitN(pattern, "/**/", 2)
+		prefix, suffix := parts[0], parts[1]
+		if !strings.HasPrefix(name, prefix+"/") {
+			return false
+		}
+		segs := strings.Split(strings.TrimPrefix(name, prefix+"/"), "/")
+		for i := range segs {
+			if matchGlob(suffix, strings.Join(segs[i:], "/")) {
+				return true
+			}
+		}
+		return false
+	default:
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+}
+
+// isIgnored reports whether name (and whether it's a directory) is
+// ignored by the accumulated ignore sets, outermost directory first. A
+// later pattern, whether in a deeper directory's ignore file or further
+// down the same file, overrides an earlier one; "!" patterns re-include.
+func isIgnored(name string, isDir bool, stack []ignoreSet) bool {
+	ignored := false
+	for _, set := range stack {
+		rel, ok := relTo(set.base, name)
+		if !ok {
+			continue
+		}
+		for _, p := range set.patterns {
+			if p.match(rel, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+	return ignored
+}
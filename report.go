@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// reportData is everything a Reporter needs to render a run: the
+// per-language totals plus enough metadata (how long the scan took) for
+// formats like the cloc-compatible JSON schema that report it.
+type reportData struct {
+	Info    map[string]*stats
+	Elapsed time.Duration
+	Files   []fileResult
+	ByFile  bool
+}
+
+// sortedFiles sorts Files by most code first, ties broken alphabetically
+// by name (sortedResults breaks its ties in reverse name order).
+func (d reportData) sortedFiles() fdata {
+	rows := append(fdata(nil), d.Files...)
+	sort.Sort(rows)
+	return rows
+}
+
+// sortedResults flattens Info into ldata, sorted the same way printInfo
+// has always sorted it, with a synthetic "Total" row appended.
+func (d reportData) sortedResults() ldata {
+	rows := ldata([]lresult{})
+	total := &lresult{Name: "Total"}
+	for n, i := range d.Info {
+		r := lresult{
+			n,
+			i.FileCount,
+			i.CodeLines,
+			i.CommentLines,
+			i.BlankLines,
+			i.TotalLines,
+			i.IgnoredDuplicates,
+		}
+		rows = append(rows, r)
+		total.Add(r)
+	}
+	rows = append(rows, *total)
+	sort.Sort(rows)
+	return rows
+}
+
+// Reporter renders a finished scan to w in a particular output format.
+type Reporter interface {
+	Report(w io.Writer, d reportData) error
+}
+
+// reporters maps the -output flag's accepted values to their Reporter.
+var reporters = map[string]Reporter{
+	"tabular":   tabularReporter{},
+	"json":      jsonReporter{},
+	"xml":       xmlReporter{},
+	"csv":       csvReporter{},
+	"sloccount": sloccountReporter{},
+	"cloc":      clocReporter{},
+}
+
+type tabularReporter struct{}
+
+func (tabularReporter) Report(w io.Writer, d reportData) error {
+	tw := tabwriter.NewWriter(w, 2, 8, 2, ' ', tabwriter.AlignRight)
+	if d.ByFile {
+		fmt.Fprintln(tw, "File\tLanguage\tCode\tComment\tBlank\tTotal\t")
+		for _, f := range d.sortedFiles() {
+			fmt.Fprintf(
+				tw,
+				"%s\t%s\t%d\t%d\t%d\t%d\t\n",
+				f.Name, f.Lang, f.CodeLines, f.CommentLines, f.BlankLines, f.TotalLines)
+		}
+		return tw.Flush()
+	}
+	fmt.Fprintln(tw, "Language\tFiles\tCode\tComment\tBlank\tTotal\tDup\t")
+	for _, i := range d.sortedResults() {
+		fmt.Fprintf(
+			tw,
+			"%s\t%d\t%d\t%d\t%d\t%d\t%d\t\n",
+			i.Name,
+			i.FileCount,
+			i.CodeLines,
+			i.CommentLines,
+			i.BlankLines,
+			i.TotalLines,
+			i.IgnoredDuplicates)
+	}
+	return tw.Flush()
+}
+
+type jsonReporter struct{}
+
+func (jsonReporter) Report(w io.Writer, d reportData) error {
+	var v interface{} = d.Info
+	if d.ByFile {
+		v = d.sortedFiles()
+	}
+	bs, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(bs))
+	return err
+}
+
+// xmlReporter emits a cloc-xml-style report: one <language> element per
+// row with the counts as attributes, which is what CI tooling that already
+// parses cloc's --xml output expects.
+type xmlReporter struct{}
+
+type xmlResults struct {
+	XMLName xml.Name  `xml:"results"`
+	Header  xmlHeader `xml:"header"`
+	Langs   []xmlLang `xml:"languages>language"`
+	Files   []xmlFile `xml:"files>file"`
+}
+
+type xmlHeader struct {
+	NFiles int `xml:"n_files,attr"`
+}
+
+type xmlLang struct {
+	Name       string `xml:"name,attr"`
+	FilesCount int    `xml:"files_count,attr"`
+	Blank      int    `xml:"blank,attr"`
+	Comment    int    `xml:"comment,attr"`
+	Code       int    `xml:"code,attr"`
+}
+
+type xmlFile struct {
+	Name    string `xml:"name,attr"`
+	Lang    string `xml:"language,attr"`
+	Blank   int    `xml:"blank,attr"`
+	Comment int    `xml:"comment,attr"`
+	Code    int    `xml:"code,attr"`
+}
+
+func (xmlReporter) Report(w io.Writer, d reportData) error {
+	results := xmlResults{}
+	if d.ByFile {
+		for _, f := range d.sortedFiles() {
+			results.Files = append(results.Files, xmlFile{
+				Name:    f.Name,
+				Lang:    f.Lang,
+				Blank:   f.BlankLines,
+				Comment: f.CommentLines,
+				Code:    f.CodeLines,
+			})
+		}
+		results.Header.NFiles = len(results.Files)
+	} else {
+		for _, i := range d.sortedResults() {
+			if i.Name == "Total" {
+				results.Header.NFiles = i.FileCount
+				continue
+			}
+			results.Langs = append(results.Langs, xmlLang{
+				Name:       i.Name,
+				FilesCount: i.FileCount,
+				Blank:      i.BlankLines,
+				Comment:    i.CommentLines,
+				Code:       i.CodeLines,
+			})
+		}
+	}
+	bs, err := xml.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprint(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(bs))
+	return err
+}
+
+type csvReporter struct{}
+
+func (csvReporter) Report(w io.Writer, d reportData) error {
+	cw := csv.NewWriter(w)
+	if d.ByFile {
+		if err := cw.Write([]string{"file", "language", "blank", "comment", "code"}); err != nil {
+			return err
+		}
+		for _, f := range d.sortedFiles() {
+			if err := cw.Write([]string{
+				f.Name,
+				f.Lang,
+				fmt.Sprint(f.BlankLines),
+				fmt.Sprint(f.CommentLines),
+				fmt.Sprint(f.CodeLines),
+			}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	if err := cw.Write([]string{"files", "language", "blank", "comment", "code"}); err != nil {
+		return err
+	}
+	for _, i := range d.sortedResults() {
+		if i.Name == "Total" {
+			continue
+		}
+		if err := cw.Write([]string{
+			fmt.Sprint(i.FileCount),
+			i.Name,
+			fmt.Sprint(i.BlankLines),
+			fmt.Sprint(i.CommentLines),
+			fmt.Sprint(i.CodeLines),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// sloccountReporter mimics the classic sloccount(1) summary: a
+// percentage-of-total breakdown by language followed by the grand total.
+// It omits sloccount's COCOMO effort estimate, which depends on a cost
+// model this tool has no equivalent input for.
+type sloccountReporter struct{}
+
+func (sloccountReporter) Report(w io.Writer, d reportData) error {
+	if d.ByFile {
+		files := d.sortedFiles()
+		total := 0
+		for _, f := range files {
+			total += f.CodeLines
+		}
+		fmt.Fprintln(w, "Totals grouped by file (dominant file first):")
+		for _, f := range files {
+			fmt.Fprintf(w, "%s:\t%d\n", f.Name, f.CodeLines)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Total Physical Source Lines of Code (SLOC) = %d\n", total)
+		return nil
+	}
+
+	rows := d.sortedResults()
+	var total lresult
+	for _, r := range rows {
+		if r.Name == "Total" {
+			total = r
+		}
+	}
+
+	fmt.Fprintln(w, "Totals grouped by language (dominant language first):")
+	for _, r := range rows {
+		if r.Name == "Total" {
+			continue
+		}
+		pct := 0.0
+		if total.CodeLines > 0 {
+			pct = 100 * float64(r.CodeLines) / float64(total.CodeLines)
+		}
+		fmt.Fprintf(w, "%s:\t%d (%.2f%%)\n", r.Name, r.CodeLines, pct)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Total Physical Source Lines of Code (SLOC) = %d\n", total.CodeLines)
+	return nil
+}
+
+// clocReporter emits cloc's --json schema: a "header" object plus one
+// object per language keyed by its name, so existing cloc-JSON consumers
+// in CI don't need a parser change to accept sloc's output.
+type clocReporter struct{}
+
+func (clocReporter) Report(w io.Writer, d reportData) error {
+	header := map[string]interface{}{
+		"elapsed_seconds": d.Elapsed.Seconds(),
+	}
+	out := map[string]interface{}{}
+
+	if d.ByFile {
+		files := d.sortedFiles()
+		for _, f := range files {
+			out[f.Name] = map[string]interface{}{
+				"language": f.Lang,
+				"blank":    f.BlankLines,
+				"comment":  f.CommentLines,
+				"code":     f.CodeLines,
+			}
+		}
+		header["n_files"] = len(files)
+		out["header"] = header
+		bs, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(bs))
+		return err
+	}
+
+	for _, r := range d.sortedResults() {
+		if r.Name == "Total" {
+			header["n_files"] = r.FileCount
+			continue
+		}
+		out[r.Name] = map[string]int{
+			"nFiles":  r.FileCount,
+			"blank":   r.BlankLines,
+			"comment": r.CommentLines,
+			"code":    r.CodeLines,
+		}
+	}
+	out["header"] = header
+	bs, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(bs))
+	return err
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
+	"crypto/md5"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"runtime"
 	"runtime/pprof"
-	"sort"
 	"strings"
-	"text/tabwriter"
+	"sync"
+	"time"
 )
 
 const version = `0.1.1`
@@ -58,8 +60,8 @@ var languages = []language{
 	language{"HTML", mExt(".htm", ".html", ".xhtml"), xmlComments},
 	language{"XML", mExt(".xml"), xmlComments},
 	language{"CSS", mExt(".css"), cssComments},
-	language{"JavaScript", mExt(".js"), cComments},
-	language{"TypeScript", mExt(".ts", ".tsx"), cComments},
+	language{"JavaScript", mExt(".js"), jsComments},
+	language{"TypeScript", mExt(".ts", ".tsx"), jsComments},
 	language{"JSON", mExt(".json"), noComments},
 }
 
@@ -68,19 +70,59 @@ type commenter struct {
 	StartComment string
 	EndComment   string
 	Nesting      bool
+
+	// StringDelims are paired start/end delimiters (e.g. `"`/`"`) for
+	// string literals that support backslash escapes. While the scanner
+	// is inside one, comment tokens are not recognized.
+	StringDelims [][2]string
+
+	// RawStringDelims are like StringDelims but without escape handling,
+	// for literals such as Go's `...` or Python's """...""". Checked
+	// before StringDelims, so put longer/more specific delimiters
+	// (triple quotes, backticks) here even if they share a prefix with
+	// a StringDelims entry.
+	RawStringDelims [][2]string
+
+	// RegexLiteral enables JS-style /.../flags regex literal detection:
+	// a bare "/" is treated as opening a regex, rather than division,
+	// when it follows an operator, opening bracket, or line start.
+	RegexLiteral bool
 }
 
 var (
-	noComments   = commenter{"\000", "\000", "\000", false}
-	xmlComments  = commenter{"\000", `<!--`, `-->`, false}
-	cComments    = commenter{`//`, `/*`, `*/`, false}
-	cssComments  = commenter{"\000", `/*`, `*/`, false}
-	shComments   = commenter{`#`, "\000", "\000", false}
-	semiComments = commenter{`;`, "\000", "\000", false}
-	hsComments   = commenter{`--`, `{-`, `-}`, true}
-	sqlComments  = commenter{`--`, "\000", "\000", false}
-	pyComments   = commenter{`#`, `"""`, `"""`, false}
-	pasComments  = commenter{`//`, `{`, `}`, false}
+	noComments   = commenter{LineComment: "\000", StartComment: "\000", EndComment: "\000"}
+	xmlComments  = commenter{LineComment: "\000", StartComment: `<!--`, EndComment: `-->`}
+	cComments    = commenter{
+		LineComment:     `//`,
+		StartComment:    `/*`,
+		EndComment:      `*/`,
+		StringDelims:    [][2]string{{`"`, `"`}, {`'`, `'`}},
+		RawStringDelims: [][2]string{{"`", "`"}},
+	}
+	jsComments = func() commenter {
+		c := cComments
+		c.RegexLiteral = true
+		return c
+	}()
+	cssComments  = commenter{LineComment: "\000", StartComment: `/*`, EndComment: `*/`}
+	shComments   = commenter{LineComment: `#`, StartComment: "\000", EndComment: "\000"}
+	semiComments = commenter{LineComment: `;`, StartComment: "\000", EndComment: "\000"}
+	hsComments   = commenter{
+		LineComment:  `--`,
+		StartComment: `{-`,
+		EndComment:   `-}`,
+		Nesting:      true,
+		StringDelims: [][2]string{{`"`, `"`}},
+	}
+	sqlComments = commenter{LineComment: `--`, StartComment: "\000", EndComment: "\000"}
+	pyComments  = commenter{
+		LineComment:     `#`,
+		StartComment:    "\000",
+		EndComment:      "\000",
+		StringDelims:    [][2]string{{`"`, `"`}, {`'`, `'`}},
+		RawStringDelims: [][2]string{{`"""`, `"""`}, {`'''`, `'''`}},
+	}
+	pasComments = commenter{LineComment: `//`, StartComment: `{`, EndComment: `}`}
 )
 
 type language struct {
@@ -89,63 +131,144 @@ type language struct {
 	commenter
 }
 
+// matchStringStart checks c against com's RawStringDelims (checked first,
+// since they're typically the longer/more specific delimiters, e.g. a
+// triple quote before a single quote) and then StringDelims, returning
+// the matched start delimiter, its corresponding end delimiter, and
+// whether the match was a raw (non-escaping) string. delim is nil if c
+// doesn't open any configured string literal.
+func matchStringStart(com commenter, c []byte) (delim, end []byte, raw bool) {
+	for _, pair := range com.RawStringDelims {
+		if bytes.HasPrefix(c, []byte(pair[0])) {
+			return []byte(pair[0]), []byte(pair[1]), true
+		}
+	}
+	for _, pair := range com.StringDelims {
+		if bytes.HasPrefix(c, []byte(pair[0])) {
+			return []byte(pair[0]), []byte(pair[1]), false
+		}
+	}
+	return nil, nil, false
+}
+
+// scanState is which region of the source l.Update is currently inside.
+type scanState int
+
+const (
+	stateCode scanState = iota
+	stateLineComment
+	stateBlockComment
+	stateString
+)
+
+// regexPrecedingBytes are the code bytes after which a bare "/" is taken
+// to open a regex literal rather than mean division. Anything else
+// (an identifier, a closing paren/bracket, a literal) means division.
+const regexPrecedingBytes = "([{,;:=!&|?+-~*%^<>\n\x00"
+
 // TODO work properly with unicode
 func (l language) Update(c []byte, s *stats) {
 	s.FileCount++
 
-	inComment := 0 // this is an int for nesting
-	inLComment := false
-	blank := true
 	lc := []byte(l.LineComment)
 	sc := []byte(l.StartComment)
 	ec := []byte(l.EndComment)
-	lp, sp, ep := 0, 0, 0
-
-	for _, b := range c {
-		if inComment == 0 && b == lc[lp] {
-			lp++
-			if lp == len(lc) {
-				inLComment = true
-				lp = 0
-			}
-		} else {
-			lp = 0
+
+	state := stateCode
+	blockDepth := 0
+	var stringEnd []byte
+	escapable := false
+	escaping := false
+	prevCodeByte := byte(0) // last non-space code byte seen, for the regex heuristic
+
+	blank := true
+	for i := 0; i < len(c); {
+		b := c[i]
+		if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
+			blank = false
 		}
-		if !inLComment && b == sc[sp] {
-			sp++
-			if sp == len(sc) {
-				inComment++
-				if inComment > 1 && !l.Nesting {
-					inComment = 1
-				}
-				sp = 0
+
+		switch state {
+		case stateString:
+			switch {
+			case escaping:
+				// The previous byte was a "\", so this one is a
+				// literal (even "\n" for a line-continued string);
+				// it still falls through to the per-line counting
+				// below, just not as a potential terminator.
+				escaping = false
+				i++
+			case escapable && b == '\\':
+				escaping = true
+				i++
+			case bytes.HasPrefix(c[i:], stringEnd):
+				i += len(stringEnd)
+				state = stateCode
+				stringEnd = nil
+				// A string/regex literal just closed, so it left a
+				// value behind; a following "/" is division, not a
+				// new regex, same as after a closing paren.
+				prevCodeByte = ')'
+			default:
+				i++
 			}
-		} else {
-			sp = 0
-		}
-		if !inLComment && inComment > 0 && b == ec[ep] {
-			ep++
-			if ep == len(ec) {
-				if inComment > 0 {
-					inComment--
+
+		case stateLineComment:
+			i++
+
+		case stateBlockComment:
+			switch {
+			case l.Nesting && len(sc) > 0 && bytes.HasPrefix(c[i:], sc):
+				blockDepth++
+				i += len(sc)
+			case bytes.HasPrefix(c[i:], ec):
+				blockDepth--
+				i += len(ec)
+				if blockDepth == 0 {
+					state = stateCode
 				}
-				ep = 0
+			default:
+				i++
 			}
-		} else {
-			ep = 0
-		}
 
-		if b != byte(' ') && b != byte('\t') && b != byte('\n') && b != byte('\r') {
-			blank = false
+		case stateCode:
+			switch {
+			case len(lc) > 0 && bytes.HasPrefix(c[i:], lc):
+				state = stateLineComment
+				i += len(lc)
+			case len(sc) > 0 && bytes.HasPrefix(c[i:], sc):
+				state = stateBlockComment
+				blockDepth = 1
+				i += len(sc)
+			default:
+				if delim, end, raw := matchStringStart(l.commenter, c[i:]); delim != nil {
+					state = stateString
+					stringEnd = end
+					escapable = !raw
+					i += len(delim)
+				} else if l.RegexLiteral && b == '/' && strings.IndexByte(regexPrecedingBytes, prevCodeByte) >= 0 {
+					state = stateString
+					stringEnd = []byte{'/'}
+					escapable = true
+					i++
+				} else {
+					if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
+						prevCodeByte = b
+					}
+					i++
+				}
+			}
 		}
 
 		// BUG(srl): lines with comment don't count towards code
 		// Note that lines with both code and comment count towards
 		// each, but are not counted twice in the total.
-		if b == byte('\n') {
+		if b == '\n' {
 			s.TotalLines++
-			if inComment > 0 || inLComment {
-				inLComment = false
+			if state == stateLineComment || state == stateBlockComment {
+				if state == stateLineComment {
+					state = stateCode
+				}
 				s.CommentLines++
 			} else if blank {
 				s.BlankLines++
@@ -153,7 +276,7 @@ func (l language) Update(c []byte, s *stats) {
 				s.CodeLines++
 			}
 			blank = true
-			continue
+			prevCodeByte = '\n'
 		}
 	}
 }
@@ -189,44 +312,131 @@ func mName(names ...string) matcher {
 }
 
 type stats struct {
-	FileCount    int
-	TotalLines   int
-	CodeLines    int
-	BlankLines   int
-	CommentLines int
+	FileCount         int
+	TotalLines        int
+	CodeLines         int
+	BlankLines        int
+	CommentLines      int
+	IgnoredDuplicates int
+}
+
+func (s *stats) Add(a stats) {
+	s.FileCount += a.FileCount
+	s.TotalLines += a.TotalLines
+	s.CodeLines += a.CodeLines
+	s.BlankLines += a.BlankLines
+	s.CommentLines += a.CommentLines
+	s.IgnoredDuplicates += a.IgnoredDuplicates
+}
+
+// seenHashes records the MD5 of every file counted so far when
+// -no-duplicates is set, guarded by seenHashesMu since workers hash
+// files concurrently.
+var (
+	seenHashesMu sync.Mutex
+	seenHashes   = map[[16]byte]struct{}{}
+)
+
+// seen reports whether sum has already been recorded, recording it if not.
+func seen(sum [16]byte) bool {
+	seenHashesMu.Lock()
+	defer seenHashesMu.Unlock()
+	if _, ok := seenHashes[sum]; ok {
+		return true
+	}
+	seenHashes[sum] = struct{}{}
+	return false
 }
 
 var info = map[string]*stats{}
 
-func handleFile(fname string) {
+// fileResults holds one entry per file when -by-file is set; left empty
+// otherwise so a normal run doesn't pay for tracking it. Files skipped by
+// -no-duplicates are left out rather than reported as all-zero-count
+// entries indistinguishable from a genuinely empty file.
+var fileResults []fileResult
+
+// fileDelta is the per-file result a worker hands off to the collector.
+type fileDelta struct {
+	fname string
+	lang  string
+	stats stats
+}
+
+// languageByName indexes languages by name for the content classifier,
+// which identifies a language without going back through languages itself.
+var languageByName = func() map[string]language {
+	m := make(map[string]language, len(languages))
+	for _, l := range languages {
+		m[l.Name()] = l
+	}
+	return m
+}()
+
+// ambiguousExts lists extensions that don't uniquely identify a language,
+// so an mExt/mName match against them is only a first guess: handleFile
+// still runs the classifier, narrowed to these candidates, and only keeps
+// the extension-based guess if the classifier doesn't reach its own verdict.
+//
+// .h, .m and .pl are deliberately not listed here: their real ambiguity is
+// with languages (C++/Objective-C, Objective-C/MATLAB, Perl/Prolog) that
+// either aren't registered in languages or have no entry in langTokenFreq,
+// so classifyBayes could never produce a usable verdict for them — at
+// best a no-op, at worst (".m", which otherwise has no mExt match at all)
+// it would wrongly narrow the extensionless fallback and drop the file.
+var ambiguousExts = map[string][]string{
+	".ts": {"TypeScript"},
+}
+
+// handleFile reads and tokenizes fname, returning the delta to merge into
+// info. ok is false if fname doesn't match a known language or can't be read.
+func handleFile(fname string) (d fileDelta, ok bool) {
 	var l language
-	ok := false
+	matched := false
 	for _, lang := range languages {
 		if lang.Match(fname) {
-			ok = true
+			matched = true
 			l = lang
 			break
 		}
 	}
-	if !ok {
-		return // ignore this file
-	}
-	i, ok := info[l.Name()]
-	if !ok {
-		i = &stats{}
-		info[l.Name()] = i
-	}
+
 	c, err := ioutil.ReadFile(fname)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "  ! %s\n", fname)
-		return
+		return d, false
 	}
-	l.Update(c, i)
-}
 
-var files []string
+	if candidates, ambiguous := ambiguousExts[path.Ext(fname)]; !matched || ambiguous {
+		// Extensionless files (scripts, Dockerfiles) get an unrestricted
+		// classification; ambiguous extensions are narrowed to their
+		// known candidates so the classifier can only pick among them.
+		m := make(map[string]float64, len(candidates))
+		for _, name := range candidates {
+			m[name] = 1
+		}
+		if langs := defaultClassifier.Classify(c, m); len(langs) > 0 {
+			if lang, ok := languageByName[langs[0]]; ok {
+				matched = true
+				l = lang
+			}
+		}
+	}
+	if !matched {
+		return d, false
+	}
 
-func add(n string) {
+	d.fname = fname
+	d.lang = l.Name()
+	if *noDuplicates && seen(md5.Sum(c)) {
+		d.stats.IgnoredDuplicates = 1
+		return d, true
+	}
+	l.Update(c, &d.stats)
+	return d, true
+}
+
+func add(n string, stack []ignoreSet, pathCh chan<- string) {
 	fi, err := os.Stat(n)
 	if err != nil {
 		goto invalid
@@ -239,19 +449,30 @@ func add(n string) {
 				}
 			}
 		}
+		dirStack := stack
+		if !*noGitignore {
+			if set, ok := loadIgnoreSet(n); ok {
+				dirStack = append(append([]ignoreSet{}, stack...), set)
+			}
+		}
 		fs, err := ioutil.ReadDir(n)
 		if err != nil {
 			goto invalid
 		}
 		for _, f := range fs {
-			if f.Name()[0] != '.' {
-				add(path.Join(n, f.Name()))
+			if f.Name()[0] == '.' {
+				continue
+			}
+			child := path.Join(n, f.Name())
+			if isIgnored(child, f.IsDir(), dirStack) {
+				continue
 			}
+			add(child, dirStack, pathCh)
 		}
 		return
 	}
 	if fi.Mode()&os.ModeType == 0 {
-		files = append(files, n)
+		pathCh <- n
 		return
 	}
 
@@ -261,6 +482,61 @@ invalid:
 	fmt.Fprintf(os.Stderr, "  ! %s\n", n)
 }
 
+// scan walks roots with a single producer goroutine and fans file paths out
+// to *workers worker goroutines that read and tokenize files concurrently.
+// Deltas are merged into info by the calling goroutine, so info itself
+// never needs locking.
+func scan(roots []string, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	pathCh := make(chan string)
+	go func() {
+		for _, n := range roots {
+			add(n, nil, pathCh)
+		}
+		close(pathCh)
+	}()
+
+	deltaCh := make(chan fileDelta)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for fname := range pathCh {
+				if d, ok := handleFile(fname); ok {
+					deltaCh <- d
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(deltaCh)
+	}()
+
+	for d := range deltaCh {
+		i, ok := info[d.lang]
+		if !ok {
+			i = &stats{}
+			info[d.lang] = i
+		}
+		i.Add(d.stats)
+		if *byFile && d.stats.IgnoredDuplicates == 0 {
+			fileResults = append(fileResults, fileResult{
+				Name:         d.fname,
+				Lang:         d.lang,
+				CodeLines:    d.stats.CodeLines,
+				CommentLines: d.stats.CommentLines,
+				BlankLines:   d.stats.BlankLines,
+				TotalLines:   d.stats.TotalLines,
+			})
+		}
+	}
+}
+
 type ldata []lresult
 
 func (d ldata) Len() int { return len(d) }
@@ -277,12 +553,13 @@ func (d ldata) Swap(i, j int) {
 }
 
 type lresult struct {
-	Name         string
-	FileCount    int
-	CodeLines    int
-	CommentLines int
-	BlankLines   int
-	TotalLines   int
+	Name              string
+	FileCount         int
+	CodeLines         int
+	CommentLines      int
+	BlankLines        int
+	TotalLines        int
+	IgnoredDuplicates int
 }
 
 func (r *lresult) Add(a lresult) {
@@ -291,58 +568,46 @@ func (r *lresult) Add(a lresult) {
 	r.CommentLines += a.CommentLines
 	r.BlankLines += a.BlankLines
 	r.TotalLines += a.TotalLines
+	r.IgnoredDuplicates += a.IgnoredDuplicates
 }
 
-func printJSON() {
-	bs, err := json.MarshalIndent(info, "", "  ")
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println(string(bs))
+// fileResult is one row of -by-file output: a single file's counts,
+// rather than a language's aggregated totals.
+type fileResult struct {
+	Name         string
+	Lang         string
+	CodeLines    int
+	CommentLines int
+	BlankLines   int
+	TotalLines   int
 }
 
-func printInfo() {
-	w := tabwriter.NewWriter(os.Stdout, 2, 8, 2, ' ', tabwriter.AlignRight)
-	fmt.Fprintln(w, "Language\tFiles\tCode\tComment\tBlank\tTotal\t")
-	d := ldata([]lresult{})
-	total := &lresult{}
-	total.Name = "Total"
-	for n, i := range info {
-		r := lresult{
-			n,
-			i.FileCount,
-			i.CodeLines,
-			i.CommentLines,
-			i.BlankLines,
-			i.TotalLines,
-		}
-		d = append(d, r)
-		total.Add(r)
-	}
-	d = append(d, *total)
-	sort.Sort(d)
-	//d[0].Name = "Total"
-	for _, i := range d {
-		fmt.Fprintf(
-			w,
-			"%s\t%d\t%d\t%d\t%d\t%d\t\n",
-			i.Name,
-			i.FileCount,
-			i.CodeLines,
-			i.CommentLines,
-			i.BlankLines,
-			i.TotalLines)
+type fdata []fileResult
+
+func (d fdata) Len() int { return len(d) }
+
+func (d fdata) Less(i, j int) bool {
+	if d[i].CodeLines == d[j].CodeLines {
+		return d[i].Name < d[j].Name
 	}
+	return d[i].CodeLines > d[j].CodeLines
+}
 
-	w.Flush()
+func (d fdata) Swap(i, j int) {
+	d[i], d[j] = d[j], d[i]
 }
 
 var (
-	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
-	useJSON    = flag.Bool("json", false, "JSON-format output")
-	v          = flag.Bool("V", false, "display version info and exit")
-	dirs       = flag.String("ignore", "", `ignore directory names i.e -ignore "dist,node_modules,vendor"`)
-	ignoreDirs []string
+	cpuprofile   = flag.String("cpuprofile", "", "write cpu profile to file")
+	output       = flag.String("output", "tabular", "output format: tabular, json, xml, csv, sloccount, cloc")
+	useJSON      = flag.Bool("json", false, "JSON-format output (deprecated, use -output json)")
+	v            = flag.Bool("V", false, "display version info and exit")
+	dirs         = flag.String("ignore", "", `ignore directory names i.e -ignore "dist,node_modules,vendor"`)
+	workers      = flag.Int("j", runtime.NumCPU(), "number of worker goroutines to tokenize files with")
+	noDuplicates = flag.Bool("no-duplicates", false, "skip counting files whose content (by MD5) has already been seen")
+	byFile       = flag.Bool("by-file", false, "report statistics per file instead of aggregated per language")
+	noGitignore  = flag.Bool("no-gitignore", false, "don't honor .gitignore/.slocignore files while walking")
+	ignoreDirs   []string
 )
 
 func main() {
@@ -370,17 +635,21 @@ func main() {
 		ignoreDirs = strings.Split(*dirs, ",")
 	}
 
-	for _, n := range args {
-		add(n)
-	}
-
-	for _, f := range files {
-		handleFile(f)
-	}
+	start := time.Now()
+	scan(args, *workers)
 
+	format := *output
 	if *useJSON {
-		printJSON()
-	} else {
-		printInfo()
+		format = "json"
+	}
+	r, ok := reporters[format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: unknown -output format %q\n", format)
+		os.Exit(1)
+	}
+	d := reportData{Info: info, Elapsed: time.Since(start), Files: fileResults, ByFile: *byFile}
+	if err := r.Report(os.Stdout, d); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(1)
 	}
 }
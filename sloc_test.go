@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+func TestLanguageUpdateStringAwareness(t *testing.T) {
+	cases := []struct {
+		name    string
+		lang    language
+		src     string
+		code    int
+		comment int
+		blank   int
+	}{
+		{
+			name: "go line-comment token inside a string is not a comment",
+			lang: language{"Go", mExt(".go"), cComments},
+			src:  "x := \"http://example.com\"\n",
+			code: 1,
+		},
+		{
+			name:    "go trailing comment still counts the whole line as comment",
+			lang:    language{"Go", mExt(".go"), cComments},
+			src:     "y := 1 // comment\n",
+			comment: 1,
+		},
+		{
+			name: "go escaped quote does not end the string early",
+			lang: language{"Go", mExt(".go"), cComments},
+			src:  `s := "she said \"hi\" // not a comment"` + "\n",
+			code: 1,
+		},
+		{
+			name:    "python quote inside a real comment does not open a string",
+			lang:    language{"Python", mExt(".py"), pyComments},
+			src:     "# this is a comment with a \"quote\n",
+			comment: 1,
+		},
+		{
+			name: "python triple-quoted docstring is a string, not a comment token source",
+			lang: language{"Python", mExt(".py"), pyComments},
+			src:  `x = """not # a comment"""` + "\n",
+			code: 1,
+		},
+		{
+			name:  "blank lines",
+			lang:  language{"Go", mExt(".go"), cComments},
+			src:   "\n   \n",
+			blank: 2,
+		},
+		{
+			name: "haskell nested block comment spans lines until fully closed",
+			lang: language{"Haskell", mExt(".hs"), hsComments},
+			src: "{- outer\n" +
+				"   {- inner -}\n" +
+				"   still outer -} -- trailing note\n" +
+				"x = 1\n",
+			code:    1,
+			comment: 3,
+		},
+		{
+			name:    "js bare slash after an identifier is division, not a regex",
+			lang:    language{"JavaScript", mExt(".js"), jsComments},
+			src:     "var x = a / b // not a regex, just division\n",
+			comment: 1,
+		},
+		{
+			name: "js regex literal honors an escaped slash before closing",
+			lang: language{"JavaScript", mExt(".js"), jsComments},
+			src: "var re = /\\//;\n" +
+				"// trailing real comment\n",
+			code:    1,
+			comment: 1,
+		},
+		{
+			name: "js bare slash right after a closed string is division, not a new regex",
+			lang: language{"JavaScript", mExt(".js"), jsComments},
+			src: `var x = "abc" / 2;` + "\n" +
+				"function real() { // this is a real comment\n" +
+				"}\n",
+			code:    2,
+			comment: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var s stats
+			tc.lang.Update([]byte(tc.src), &s)
+			if s.CodeLines != tc.code || s.CommentLines != tc.comment || s.BlankLines != tc.blank {
+				t.Errorf("Update(%q) = {code:%d comment:%d blank:%d}, want {code:%d comment:%d blank:%d}",
+					tc.src, s.CodeLines, s.CommentLines, s.BlankLines, tc.code, tc.comment, tc.blank)
+			}
+		})
+	}
+}